@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RepoConfig is one repository mergewarn should track: where it lives on
+// disk, which branch it's diffed against, and which Redis namespace its
+// state is kept under (so two repos configured with different namespaces
+// never collide in Redis).
+type RepoConfig struct {
+	Dir       string `yaml:"repo_dir" json:"repo_dir"`
+	Base      string `yaml:"base_branch" json:"base_branch"`
+	Namespace string `yaml:"redis_namespace" json:"redis_namespace"`
+
+	// ForgeRepo is this repo's owner/name slug on the forge configured via
+	// -forge/-forge-url/-forge-token. It falls back to the global -repo
+	// flag when empty, so a single-repo setup can keep using -repo alone;
+	// once more than one repo is configured, each needs its own ForgeRepo
+	// so conflict notifications land on the right repo's pull request.
+	ForgeRepo string `yaml:"forge_repo" json:"forge_repo"`
+}
+
+// Config is the top-level shape of the -config file.
+type Config struct {
+	Repos []RepoConfig `yaml:"repos" json:"repos"`
+}
+
+// loadConfig reads and parses a -config file. It accepts both YAML and
+// plain JSON, since JSON is valid YAML flow syntax.
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	if err := validateNamespaces(cfg.Repos); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// validateNamespaces rejects repo lists where any entry is missing its
+// redis_namespace or shares one with another entry: two repos sharing a
+// namespace would silently share Redis state (diffs, newChange pubsub)
+// and merge each other's conflict data.
+func validateNamespaces(repos []RepoConfig) error {
+	seen := make(map[string]string, len(repos))
+	for _, r := range repos {
+		if r.Namespace == "" {
+			return fmt.Errorf("config: repo %s has no redis_namespace", r.Dir)
+		}
+		if other, ok := seen[r.Namespace]; ok {
+			return fmt.Errorf("config: repos %s and %s share redis_namespace %q", other, r.Dir, r.Namespace)
+		}
+		seen[r.Namespace] = r.Dir
+	}
+	return nil
+}
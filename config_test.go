@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestValidateNamespaces(t *testing.T) {
+	cases := []struct {
+		name    string
+		repos   []RepoConfig
+		wantErr bool
+	}{
+		{
+			name:  "distinct namespaces",
+			repos: []RepoConfig{{Dir: "a", Namespace: "a"}, {Dir: "b", Namespace: "b"}},
+		},
+		{
+			name:    "empty namespace",
+			repos:   []RepoConfig{{Dir: "a", Namespace: ""}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate namespace",
+			repos:   []RepoConfig{{Dir: "a", Namespace: "shared"}, {Dir: "b", Namespace: "shared"}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateNamespaces(c.repos)
+			if (err != nil) != c.wantErr {
+				t.Errorf("validateNamespaces(%v) = %v, wantErr %v", c.repos, err, c.wantErr)
+			}
+		})
+	}
+}
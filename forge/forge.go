@@ -0,0 +1,75 @@
+// Package forge pushes mergewarn conflict notifications out to whatever
+// pull request is open for the conflicting branch, so the warning shows up
+// where reviewers are already looking instead of only on the author's
+// terminal.
+package forge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Conflict is the subset of FileEdit forge implementations need to render
+// a comment. It's a separate type (rather than importing package main's
+// FileEdit) to keep this package standalone.
+type Conflict struct {
+	Filename    string `json:"filename"`
+	LineNumbers []int  `json:"lineNumbers"`
+	User        string `json:"user"`
+	Branch      string `json:"branch"`
+}
+
+// Forge posts (or updates) a conflict notification for branch on whatever
+// forge-hosted pull request is open for it.
+type Forge interface {
+	AnnotateConflict(branch string, conflicts []Conflict) error
+}
+
+// commentMarker tags comments mergewarn owns, so implementations can find
+// and edit their own previous comment instead of spamming a new one every
+// time the conflict set changes.
+const commentMarker = "<!-- mergewarn:conflict -->"
+
+// renderComment builds the markdown body posted to the forge. Shared by
+// every implementation so the notification looks the same regardless of
+// which forge is configured.
+func renderComment(branch string, conflicts []Conflict) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", commentMarker)
+
+	if len(conflicts) == 0 {
+		fmt.Fprintf(&b, "### ✅ mergewarn: conflicts cleared on `%s`\n", branch)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "### ⚠️ mergewarn: potential conflicts on `%s`\n\n", branch)
+
+	byUser := make(map[string][]Conflict)
+	for _, c := range conflicts {
+		byUser[c.User] = append(byUser[c.User], c)
+	}
+
+	users := make([]string, 0, len(byUser))
+	for user := range byUser {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	for _, user := range users {
+		fmt.Fprintf(&b, "- **%s** is also editing:\n", user)
+		for _, c := range byUser[user] {
+			fmt.Fprintf(&b, "  - `%s` (lines %s)\n", c.Filename, joinInts(c.LineNumbers))
+		}
+	}
+
+	return b.String()
+}
+
+func joinInts(nums []int) string {
+	parts := make([]string, len(nums))
+	for i, n := range nums {
+		parts[i] = fmt.Sprintf("%d", n)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,27 @@
+package forge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderComment(t *testing.T) {
+	cleared := renderComment("main", nil)
+	if !strings.Contains(cleared, commentMarker) || !strings.Contains(cleared, "cleared") {
+		t.Errorf("renderComment with no conflicts = %q, want the marker and a cleared message", cleared)
+	}
+
+	conflicts := []Conflict{
+		{Filename: "a.go", LineNumbers: []int{1, 2}, User: "bob", Branch: "main"},
+		{Filename: "b.go", LineNumbers: []int{9}, User: "alice", Branch: "main"},
+	}
+	got := renderComment("main", conflicts)
+	if !strings.Contains(got, commentMarker) {
+		t.Errorf("renderComment(%v) = %q, want it to contain %q", conflicts, got, commentMarker)
+	}
+	for _, want := range []string{"bob", "alice", "a.go", "b.go", "main"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("renderComment(%v) = %q, want it to mention %q", conflicts, got, want)
+		}
+	}
+}
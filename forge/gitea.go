@@ -0,0 +1,145 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Gitea annotates conflicts on Gitea pull requests via its REST API
+// (https://gitea.example.com/api/v1).
+type Gitea struct {
+	baseURL string
+	token   string
+	owner   string
+	repo    string
+	client  *http.Client
+}
+
+// NewGitea builds a Gitea forge client. repoSlug is "owner/name".
+func NewGitea(baseURL, token, repoSlug string) (*Gitea, error) {
+	owner, repo, err := splitRepoSlug(repoSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gitea{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		owner:   owner,
+		repo:    repo,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type giteaPull struct {
+	Number int `json:"number"`
+	Head   struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+type giteaComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+func (g *Gitea) AnnotateConflict(branch string, conflicts []Conflict) error {
+	pullNumber, err := g.findOpenPull(branch)
+	if err != nil {
+		return err
+	}
+	if pullNumber == 0 {
+		return nil // no open PR for this branch, nothing to annotate
+	}
+
+	body := renderComment(branch, conflicts)
+
+	existing, err := g.findOwnComment(pullNumber)
+	if err != nil {
+		return err
+	}
+	if existing != 0 {
+		return g.editComment(pullNumber, existing, body)
+	}
+	return g.createComment(pullNumber, body)
+}
+
+func (g *Gitea) findOpenPull(branch string) (int, error) {
+	url := fmt.Sprintf("%s/api/v1/repos/%s/%s/pulls?state=open", g.baseURL, g.owner, g.repo)
+	var pulls []giteaPull
+	if err := g.do("GET", url, nil, &pulls); err != nil {
+		return 0, err
+	}
+	for _, p := range pulls {
+		if p.Head.Ref == branch {
+			return p.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+func (g *Gitea) findOwnComment(issueNumber int) (int64, error) {
+	url := fmt.Sprintf("%s/api/v1/repos/%s/%s/issues/%d/comments", g.baseURL, g.owner, g.repo, issueNumber)
+	var comments []giteaComment
+	if err := g.do("GET", url, nil, &comments); err != nil {
+		return 0, err
+	}
+	for _, c := range comments {
+		if strings.Contains(c.Body, commentMarker) {
+			return c.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (g *Gitea) createComment(issueNumber int, body string) error {
+	url := fmt.Sprintf("%s/api/v1/repos/%s/%s/issues/%d/comments", g.baseURL, g.owner, g.repo, issueNumber)
+	return g.do("POST", url, map[string]string{"body": body}, nil)
+}
+
+func (g *Gitea) editComment(issueNumber int, commentID int64, body string) error {
+	url := fmt.Sprintf("%s/api/v1/repos/%s/%s/issues/comments/%d", g.baseURL, g.owner, g.repo, commentID)
+	return g.do("PATCH", url, map[string]string{"body": body}, nil)
+}
+
+func (g *Gitea) do(method, url string, payload interface{}, out interface{}) error {
+	var reqBody bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&reqBody).Encode(payload); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "token "+g.token)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("forge: gitea %s %s: %s", method, url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func splitRepoSlug(slug string) (owner, repo string, err error) {
+	parts := strings.SplitN(slug, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("forge: -repo must be owner/name, got %q", slug)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,142 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GitHub annotates conflicts on GitHub pull requests via the REST API
+// (https://api.github.com, or a GitHub Enterprise base URL).
+type GitHub struct {
+	baseURL string
+	token   string
+	owner   string
+	repo    string
+	client  *http.Client
+}
+
+// NewGitHub builds a GitHub forge client. repoSlug is "owner/name". If
+// baseURL is empty it defaults to the public GitHub API.
+func NewGitHub(baseURL, token, repoSlug string) (*GitHub, error) {
+	owner, repo, err := splitRepoSlug(repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	if baseURL == "" {
+		baseURL = "https://api.github.com"
+	}
+
+	return &GitHub{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		owner:   owner,
+		repo:    repo,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type githubPull struct {
+	Number int `json:"number"`
+	Head   struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+type githubComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+func (gh *GitHub) AnnotateConflict(branch string, conflicts []Conflict) error {
+	pullNumber, err := gh.findOpenPull(branch)
+	if err != nil {
+		return err
+	}
+	if pullNumber == 0 {
+		return nil // no open PR for this branch, nothing to annotate
+	}
+
+	body := renderComment(branch, conflicts)
+
+	existing, err := gh.findOwnComment(pullNumber)
+	if err != nil {
+		return err
+	}
+	if existing != 0 {
+		return gh.editComment(existing, body)
+	}
+	return gh.createComment(pullNumber, body)
+}
+
+func (gh *GitHub) findOpenPull(branch string) (int, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open&head=%s:%s", gh.baseURL, gh.owner, gh.repo, gh.owner, branch)
+	var pulls []githubPull
+	if err := gh.do("GET", url, nil, &pulls); err != nil {
+		return 0, err
+	}
+	for _, p := range pulls {
+		if p.Head.Ref == branch {
+			return p.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+func (gh *GitHub) findOwnComment(issueNumber int) (int64, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", gh.baseURL, gh.owner, gh.repo, issueNumber)
+	var comments []githubComment
+	if err := gh.do("GET", url, nil, &comments); err != nil {
+		return 0, err
+	}
+	for _, c := range comments {
+		if strings.Contains(c.Body, commentMarker) {
+			return c.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (gh *GitHub) createComment(issueNumber int, body string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", gh.baseURL, gh.owner, gh.repo, issueNumber)
+	return gh.do("POST", url, map[string]string{"body": body}, nil)
+}
+
+func (gh *GitHub) editComment(commentID int64, body string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/comments/%d", gh.baseURL, gh.owner, gh.repo, commentID)
+	return gh.do("PATCH", url, map[string]string{"body": body}, nil)
+}
+
+func (gh *GitHub) do(method, url string, payload interface{}, out interface{}) error {
+	var reqBody bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&reqBody).Encode(payload); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+gh.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("forge: github %s %s: %s", method, url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
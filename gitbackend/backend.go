@@ -0,0 +1,40 @@
+// Package gitbackend abstracts the git operations mergewarn needs behind
+// an interface, so the pure-Go go-git implementation (the default) and the
+// legacy git2go one (build tag "git2go", kept for parity while people
+// migrate) can be swapped without touching the rest of the daemon.
+package gitbackend
+
+// Hunk is a contiguous range of changed lines, plus a fingerprint of the
+// unchanged lines immediately before it. The fingerprint lets the server
+// side recognize "the same edit" even after the base file has shifted
+// between two users' checkouts, the same way git blame/merge use context
+// to relocate a hunk rather than trusting raw line numbers.
+type Hunk struct {
+	StartLine   int    `json:"startLine"`
+	LineCount   int    `json:"lineCount"`
+	ContextHash string `json:"contextHash"`
+}
+
+// FileDiff is everything DiffAgainstBase knows about one changed file.
+type FileDiff struct {
+	Hunks []Hunk
+
+	// BaseOID is the hex SHA1 of the file's blob in the base tree, or ""
+	// if the file didn't exist there. Two users whose BaseOID for the
+	// same file differ are diffing from different base commits, so their
+	// edits should be flagged as conflicting regardless of which lines
+	// they touched.
+	BaseOID string
+}
+
+// Backend is everything mergewarn needs from a git checkout: which branch
+// it's on, and which hunks differ from a base branch.
+type Backend interface {
+	// CurrentBranch returns the short name of the checked-out branch.
+	CurrentBranch() (string, error)
+
+	// DiffAgainstBase returns, for every file that differs between base
+	// and the current branch (or the working tree, if base is the
+	// current branch), its changed hunks and base blob id.
+	DiffAgainstBase(base string) (map[string]FileDiff, error)
+}
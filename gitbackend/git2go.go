@@ -0,0 +1,165 @@
+//go:build git2go
+// +build git2go
+
+package gitbackend
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	git "gopkg.in/libgit2/git2go.v22"
+)
+
+// git2goBackend is the original backend, kept behind the "git2go" build
+// tag for anyone not ready to move off cgo/libgit2 yet. The default build
+// (no tag) uses gogitBackend instead; see gogit.go.
+type git2goBackend struct {
+	repo *git.Repository
+}
+
+// Open opens the git repository at dir using the legacy git2go backend.
+func Open(dir string) (Backend, error) {
+	repo, err := git.OpenRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &git2goBackend{repo: repo}, nil
+}
+
+func (b *git2goBackend) CurrentBranch() (string, error) {
+	head, err := b.repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Branch().Name()
+}
+
+func (b *git2goBackend) getTreeRev(branchName string) *git.Tree {
+	rev, err := b.repo.RevparseSingle(branchName + "^{tree}")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tree, err := b.repo.LookupTree(rev.Id())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return tree
+}
+
+func (b *git2goBackend) buildDiff(base, branchName string) (*git.Diff, error) {
+	baseTree := b.getTreeRev(base)
+
+	if branchName == base {
+		// If we are working on the base branch, then just diff against
+		// the current tree.
+		// TODO: is WithIndex the right thing here?
+		diff, err := b.repo.DiffTreeToWorkdirWithIndex(baseTree, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return diff, err
+	}
+
+	otherTree := b.getTreeRev(branchName)
+	opts, err := git.DefaultDiffOptions()
+	diff, err := b.repo.DiffTreeToTree(baseTree, otherTree, &opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return diff, err
+}
+
+// blobOID looks up path's blob id in tree, returning "" if it's not there
+// (e.g. the file is new on this branch).
+func blobOID(tree *git.Tree, path string) string {
+	entry := tree.EntryByPath(path)
+	if entry == nil {
+		return ""
+	}
+	return entry.Id.String()
+}
+
+func hashContext(lines []string) string {
+	if len(lines) > contextLines {
+		lines = lines[len(lines)-contextLines:]
+	}
+	sum := sha1.Sum([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// contextLines mirrors gogit.go's constant; git2go.go can't share it
+// directly since only one of the two files is ever compiled.
+const contextLines = 3
+
+// parseDiff walks diff's hunks, grouping each hunk's added/deleted lines
+// (numbered against the new side, falling back to the old side for a
+// pure deletion) into a Hunk, fingerprinted with the hunk's own leading
+// context lines.
+func (b *git2goBackend) parseDiff(diff *git.Diff, baseTree *git.Tree) map[string]FileDiff {
+	fileDiffs := make(map[string]FileDiff)
+
+	_ = diff.ForEach(func(file git.DiffDelta, progress float64) (git.DiffForEachHunkCallback, error) {
+		path := file.OldFile.Path
+
+		return func(hunk git.DiffHunk) (git.DiffForEachLineCallback, error) {
+			var context []string
+			recorded := false
+			startLine := hunk.NewStart
+			if startLine <= 0 {
+				startLine = hunk.OldStart
+			}
+			lineCount := hunk.NewLines
+			if lineCount <= 0 {
+				lineCount = hunk.OldLines
+			}
+
+			return func(line git.DiffLine) error {
+				if line.Origin == git.DiffLineContext {
+					context = append(context, line.Content)
+					return nil
+				}
+				if line.Origin != git.DiffLineAddition && line.Origin != git.DiffLineDeletion {
+					return nil
+				}
+				if recorded {
+					return nil
+				}
+				recorded = true
+
+				fd := fileDiffs[path]
+				if fd.BaseOID == "" {
+					fd.BaseOID = blobOID(baseTree, path)
+				}
+				fd.Hunks = append(fd.Hunks, Hunk{
+					StartLine:   startLine,
+					LineCount:   lineCount,
+					ContextHash: hashContext(context),
+				})
+				fileDiffs[path] = fd
+				return nil
+			}, nil
+		}, nil
+	}, git.DiffDetailLines)
+
+	return fileDiffs
+}
+
+func (b *git2goBackend) DiffAgainstBase(base string) (map[string]FileDiff, error) {
+	branchName, err := b.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	baseTree := b.getTreeRev(base)
+
+	diff, err := b.buildDiff(base, branchName)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.parseDiff(diff, baseTree), nil
+}
@@ -0,0 +1,283 @@
+//go:build !git2go
+// +build !git2go
+
+package gitbackend
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	godiff "github.com/go-git/go-git/v5/utils/diff"
+)
+
+// contextLines is how many lines of surrounding unchanged content get
+// folded into a hunk's ContextHash, matching git's own default context
+// size for unified diffs.
+const contextLines = 3
+
+// gogitBackend is the default Backend, built on the pure-Go go-git library.
+// It needs no cgo and no system libgit2 install.
+type gogitBackend struct {
+	repo *git.Repository
+}
+
+// Open opens the git repository at dir using the default (go-git) backend.
+func Open(dir string) (Backend, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &gogitBackend{repo: repo}, nil
+}
+
+func (b *gogitBackend) CurrentBranch() (string, error) {
+	head, err := b.repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Name().Short(), nil
+}
+
+func (b *gogitBackend) DiffAgainstBase(base string) (map[string]FileDiff, error) {
+	baseTree, err := b.treeForBranch(base)
+	if err != nil {
+		return nil, err
+	}
+
+	branch, err := b.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	if branch == base {
+		// Same as the old git2go DiffTreeToWorkdirWithIndex(masterTree, nil):
+		// diff the base tree against the uncommitted working tree.
+		return b.diffTreeAgainstWorkdir(baseTree)
+	}
+
+	otherTree, err := b.treeForBranch(branch)
+	if err != nil {
+		return nil, err
+	}
+	return b.diffTrees(baseTree, otherTree)
+}
+
+func (b *gogitBackend) treeForBranch(branch string) (*object.Tree, error) {
+	ref, err := b.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := b.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+func baseOID(base *object.Tree, path string) string {
+	f, err := base.File(path)
+	if err != nil {
+		return ""
+	}
+	return f.Hash.String()
+}
+
+// diffOp is a backend-agnostic view of one chunk of a diff: either an
+// unchanged run of content, or an inserted/deleted one. buildHunks works
+// from this so the commit-to-commit (fdiff.Chunk) and tree-to-workdir
+// (diffmatchpatch.Diff) code paths can share hunk-grouping logic.
+type diffOp struct {
+	equal   bool
+	deleted bool // only meaningful when !equal
+	content string
+}
+
+// buildHunks groups consecutive non-equal ops into Hunks, numbered against
+// the "old" side of the diff when the hunk opens with a deletion and
+// against the "new" side otherwise, and fingerprints each hunk with the
+// unchanged content that precedes it.
+func buildHunks(ops []diffOp) []Hunk {
+	var hunks []Hunk
+	oldLine, newLine := 1, 1
+	var lastEqual string
+
+	inHunk := false
+	var start, count int
+	var context string
+
+	flush := func() {
+		if inHunk {
+			hunks = append(hunks, Hunk{StartLine: start, LineCount: count, ContextHash: hashContext(context)})
+			inHunk = false
+		}
+	}
+
+	for _, op := range ops {
+		lineCount := countLines(op.content)
+
+		if op.equal {
+			flush()
+			lastEqual = op.content
+			oldLine += lineCount
+			newLine += lineCount
+			continue
+		}
+
+		if !inHunk {
+			if op.deleted {
+				start = oldLine
+			} else {
+				start = newLine
+			}
+			count = 0
+			context = lastEqual
+			inHunk = true
+		}
+		count += lineCount
+
+		if op.deleted {
+			oldLine += lineCount
+		} else {
+			newLine += lineCount
+		}
+	}
+	flush()
+
+	return hunks
+}
+
+func hashContext(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	if len(lines) > contextLines {
+		lines = lines[len(lines)-contextLines:]
+	}
+	sum := sha1.Sum([]byte(strings.Join(lines, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// diffTrees mirrors the old parseDiff against two committed trees.
+func (b *gogitBackend) diffTrees(from, to *object.Tree) (map[string]FileDiff, error) {
+	changes, err := from.Diff(to)
+	if err != nil {
+		return nil, err
+	}
+
+	fileDiffs := make(map[string]FileDiff)
+	for _, change := range changes {
+		patch, err := change.Patch()
+		if err != nil {
+			return nil, err
+		}
+		for _, filePatch := range patch.FilePatches() {
+			fromFile, toFile := filePatch.Files()
+			path := ""
+			if toFile != nil {
+				path = toFile.Path()
+			} else if fromFile != nil {
+				path = fromFile.Path()
+			}
+
+			ops := make([]diffOp, 0, len(filePatch.Chunks()))
+			for _, chunk := range filePatch.Chunks() {
+				switch chunk.Type() {
+				case fdiff.Equal:
+					ops = append(ops, diffOp{equal: true, content: chunk.Content()})
+				case fdiff.Add:
+					ops = append(ops, diffOp{content: chunk.Content()})
+				case fdiff.Delete:
+					ops = append(ops, diffOp{deleted: true, content: chunk.Content()})
+				}
+			}
+
+			fileDiffs[path] = FileDiff{
+				Hunks:   buildHunks(ops),
+				BaseOID: baseOID(from, path),
+			}
+		}
+	}
+	return fileDiffs, nil
+}
+
+// diffTreeAgainstWorkdir mirrors the old parseDiff against the base tree
+// and the uncommitted contents of the working tree.
+func (b *gogitBackend) diffTreeAgainstWorkdir(base *object.Tree) (map[string]FileDiff, error) {
+	worktree, err := b.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	fileDiffs := make(map[string]FileDiff)
+	for path, fileStatus := range status {
+		// A file staged but not edited again afterwards (e.g. "git add") is
+		// Worktree: Unmodified, Staging: Added/Modified; it still needs to
+		// be diffed, same as git2go's DiffTreeToWorkdirWithIndex did.
+		if fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified {
+			continue
+		}
+
+		oldContent := ""
+		if f, err := base.File(path); err == nil {
+			if r, err := f.Reader(); err == nil {
+				contents, _ := ioutil.ReadAll(r)
+				oldContent = string(contents)
+				r.(io.Closer).Close()
+			}
+		}
+
+		newContent := ""
+		if fh, err := worktree.Filesystem.Open(path); err == nil {
+			contents, _ := ioutil.ReadAll(fh)
+			newContent = string(contents)
+			fh.Close()
+		}
+
+		ops := make([]diffOp, 0)
+		for _, d := range godiff.Do(oldContent, newContent) {
+			switch {
+			case d.Type == 0: // diffmatchpatch.DiffEqual
+				ops = append(ops, diffOp{equal: true, content: d.Text})
+			case d.Type > 0: // diffmatchpatch.DiffInsert
+				ops = append(ops, diffOp{content: d.Text})
+			default: // diffmatchpatch.DiffDelete
+				ops = append(ops, diffOp{deleted: true, content: d.Text})
+			}
+		}
+
+		hunks := buildHunks(ops)
+		if len(hunks) > 0 {
+			fileDiffs[path] = FileDiff{Hunks: hunks, BaseOID: baseOID(base, path)}
+		}
+	}
+	return fileDiffs, nil
+}
+
+func countLines(s string) int {
+	if s == "" {
+		return 0
+	}
+	count := 0
+	for _, r := range s {
+		if r == '\n' {
+			count++
+		}
+	}
+	if s[len(s)-1] != '\n' {
+		count++
+	}
+	return count
+}
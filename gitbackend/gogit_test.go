@@ -0,0 +1,73 @@
+//go:build !git2go
+// +build !git2go
+
+package gitbackend
+
+import "testing"
+
+func TestBuildHunks(t *testing.T) {
+	cases := []struct {
+		name string
+		ops  []diffOp
+		want []Hunk
+	}{
+		{
+			name: "no changes",
+			ops:  []diffOp{{equal: true, content: "a\nb\nc\n"}},
+			want: nil,
+		},
+		{
+			name: "single insertion",
+			ops: []diffOp{
+				{equal: true, content: "a\nb\n"},
+				{content: "c\n"},
+				{equal: true, content: "d\n"},
+			},
+			want: []Hunk{{StartLine: 3, LineCount: 1, ContextHash: hashContext("a\nb\n")}},
+		},
+		{
+			name: "single deletion numbered against the old side",
+			ops: []diffOp{
+				{equal: true, content: "a\n"},
+				{deleted: true, content: "b\n"},
+			},
+			want: []Hunk{{StartLine: 2, LineCount: 1, ContextHash: hashContext("a\n")}},
+		},
+		{
+			name: "adjacent insert and delete merge into one hunk",
+			ops: []diffOp{
+				{equal: true, content: "a\n"},
+				{deleted: true, content: "b\n"},
+				{content: "c\n"},
+				{equal: true, content: "d\n"},
+			},
+			want: []Hunk{{StartLine: 2, LineCount: 2, ContextHash: hashContext("a\n")}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := buildHunks(c.ops)
+			if len(got) != len(c.want) {
+				t.Fatalf("buildHunks() = %#v, want %#v", got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Fatalf("hunk %d = %#v, want %#v", i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHashContextUsesOnlyTrailingLines(t *testing.T) {
+	short := hashContext("z\na\nb\n")
+	long := hashContext("x\ny\nz\na\nb\n")
+	if short != long {
+		t.Fatalf("hashContext should only fingerprint the last %d lines, got %q != %q", contextLines, short, long)
+	}
+
+	if hashContext("a\nb\n") == hashContext("a\nc\n") {
+		t.Fatal("hashContext should differ when trailing context differs")
+	}
+}
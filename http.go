@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// httpAPI exposes every tracked repo's conflict state over HTTP so editor
+// plugins and other tools can subscribe to it instead of scraping the
+// "INCOMING|" stdout lines. Endpoints take a ?repo=namespace query param to
+// scope to one tracked repo; it can be omitted when only one is configured.
+type httpAPI struct {
+	redisClient *redis.Client
+	repos       map[string]RepoConfig // by namespace
+
+	mu            sync.Mutex
+	lastConflicts map[string][]FileEdit // by namespace
+	subscribers   map[chan namespacedConflicts]bool
+}
+
+type namespacedConflicts struct {
+	Namespace string
+	Conflicts []FileEdit
+}
+
+func newHTTPAPI(redisClient *redis.Client, repos []RepoConfig) *httpAPI {
+	byNamespace := make(map[string]RepoConfig, len(repos))
+	for _, r := range repos {
+		byNamespace[r.Namespace] = r
+	}
+
+	return &httpAPI{
+		redisClient:   redisClient,
+		repos:         byNamespace,
+		lastConflicts: make(map[string][]FileEdit),
+		subscribers:   make(map[chan namespacedConflicts]bool),
+	}
+}
+
+// Serve blocks serving the HTTP API on addr. It's meant to be run in its
+// own goroutine.
+func (a *httpAPI) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/conflicts", a.handleConflicts)
+	mux.HandleFunc("/users", a.handleUsers)
+	mux.HandleFunc("/users/", a.handleUserEdits)
+	mux.HandleFunc("/events", a.handleEvents)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+
+	fmt.Println("MergeWarn HTTP API listening on " + addr)
+	log.Println(http.ListenAndServe(addr, mux))
+}
+
+// publishConflicts records the latest conflict set for namespace and fans
+// it out to any open /events subscribers. Called from waitForServerChanges
+// every time a tracker observes a newChange message.
+func (a *httpAPI) publishConflicts(namespace string, conflicts []FileEdit) {
+	a.mu.Lock()
+	a.lastConflicts[namespace] = conflicts
+	subs := make([]chan namespacedConflicts, 0, len(a.subscribers))
+	for ch := range a.subscribers {
+		subs = append(subs, ch)
+	}
+	a.mu.Unlock()
+
+	update := namespacedConflicts{Namespace: namespace, Conflicts: conflicts}
+	for _, ch := range subs {
+		select {
+		case ch <- update:
+		default:
+			// Slow subscriber; drop the update rather than block publishing.
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// resolveNamespace picks the repo a request is scoped to: the ?repo= query
+// param if given, or the lone configured namespace if there's only one.
+func (a *httpAPI) resolveNamespace(r *http.Request) (string, bool) {
+	if ns := r.URL.Query().Get("repo"); ns != "" {
+		return ns, true
+	}
+	if len(a.repos) == 1 {
+		for ns := range a.repos {
+			return ns, true
+		}
+	}
+	return "", false
+}
+
+func (a *httpAPI) handleConflicts(w http.ResponseWriter, r *http.Request) {
+	if ns, ok := a.resolveNamespace(r); ok {
+		a.mu.Lock()
+		conflicts := a.lastConflicts[ns]
+		a.mu.Unlock()
+		if conflicts == nil {
+			conflicts = []FileEdit{}
+		}
+		writeJSON(w, conflicts)
+		return
+	}
+
+	a.mu.Lock()
+	all := make(map[string][]FileEdit, len(a.lastConflicts))
+	for ns, conflicts := range a.lastConflicts {
+		all[ns] = conflicts
+	}
+	a.mu.Unlock()
+	writeJSON(w, all)
+}
+
+func (a *httpAPI) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if ns, ok := a.resolveNamespace(r); ok {
+		users, err := a.usersForNamespace(ns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, users)
+		return
+	}
+
+	all := make(map[string][]string, len(a.repos))
+	for ns := range a.repos {
+		users, err := a.usersForNamespace(ns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		all[ns] = users
+	}
+	writeJSON(w, all)
+}
+
+func (a *httpAPI) usersForNamespace(namespace string) ([]string, error) {
+	diffUserMap, err := a.fetchAllDiffs(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(diffUserMap))
+	for user := range diffUserMap {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (a *httpAPI) handleUserEdits(w http.ResponseWriter, r *http.Request) {
+	user := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/edits")
+	if user == "" || user == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	ns, ok := a.resolveNamespace(r)
+	if !ok {
+		http.Error(w, "multiple repos tracked; specify ?repo=namespace", http.StatusBadRequest)
+		return
+	}
+
+	diffUserMap, err := a.fetchAllDiffs(ns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	edits, ok := diffUserMap[user]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, edits)
+}
+
+func (a *httpAPI) fetchAllDiffs(namespace string) (map[string][]FileEdit, error) {
+	t := tracker{redisClient: a.redisClient, cfg: RepoConfig{Namespace: namespace}}
+	return t.fetchAllDiffs()
+}
+
+func (a *httpAPI) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	filterNamespace := r.URL.Query().Get("repo")
+
+	ch := make(chan namespacedConflicts, 1)
+	a.mu.Lock()
+	a.subscribers[ch] = true
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		delete(a.subscribers, ch)
+		a.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for {
+		select {
+		case update := <-ch:
+			if filterNamespace != "" && update.Namespace != filterNamespace {
+				continue
+			}
+			jsonBody, err := json.Marshal(update)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", jsonBody)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *httpAPI) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	trackedUsers := 0
+	for ns := range a.repos {
+		users, err := a.usersForNamespace(ns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		trackedUsers += len(users)
+	}
+
+	a.mu.Lock()
+	conflictCount := 0
+	for _, conflicts := range a.lastConflicts {
+		conflictCount += len(conflicts)
+	}
+	a.mu.Unlock()
+
+	parseSeconds := time.Duration(atomic.LoadInt64(&diffParseNanos)).Seconds()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP mergewarn_tracked_users Number of users with an entry across all tracked repos' diffs\n")
+	fmt.Fprintf(w, "# TYPE mergewarn_tracked_users gauge\n")
+	fmt.Fprintf(w, "mergewarn_tracked_users %d\n", trackedUsers)
+	fmt.Fprintf(w, "# HELP mergewarn_conflict_count Number of conflicts found across all tracked repos the last time their state was compared\n")
+	fmt.Fprintf(w, "# TYPE mergewarn_conflict_count gauge\n")
+	fmt.Fprintf(w, "mergewarn_conflict_count %d\n", conflictCount)
+	fmt.Fprintf(w, "# HELP mergewarn_redis_reconnects_total Number of times a redis pubsub subscription needed to reconnect\n")
+	fmt.Fprintf(w, "# TYPE mergewarn_redis_reconnects_total counter\n")
+	fmt.Fprintf(w, "mergewarn_redis_reconnects_total %d\n", atomic.LoadUint64(&redisReconnects))
+	fmt.Fprintf(w, "# HELP mergewarn_diff_parse_seconds Duration of the most recent local diff parse, across whichever repo ran one last\n")
+	fmt.Fprintf(w, "# TYPE mergewarn_diff_parse_seconds gauge\n")
+	fmt.Fprintf(w, "mergewarn_diff_parse_seconds %f\n", parseSeconds)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fundera/mergewarn/gitbackend"
+)
+
+func TestHunksConflict(t *testing.T) {
+	cases := []struct {
+		name   string
+		a, b   []gitbackend.Hunk
+		window int
+		want   bool
+	}{
+		{
+			name: "identical lines",
+			a:    []gitbackend.Hunk{{StartLine: 10, LineCount: 2}},
+			b:    []gitbackend.Hunk{{StartLine: 10, LineCount: 2}},
+			want: true,
+		},
+		{
+			name: "far apart, no window",
+			a:    []gitbackend.Hunk{{StartLine: 10, LineCount: 1}},
+			b:    []gitbackend.Hunk{{StartLine: 100, LineCount: 1}},
+			want: false,
+		},
+		{
+			name:   "just outside the window",
+			a:      []gitbackend.Hunk{{StartLine: 10, LineCount: 1}},
+			b:      []gitbackend.Hunk{{StartLine: 14, LineCount: 1}},
+			window: 2,
+			want:   false,
+		},
+		{
+			name:   "just inside the window",
+			a:      []gitbackend.Hunk{{StartLine: 10, LineCount: 1}},
+			b:      []gitbackend.Hunk{{StartLine: 12, LineCount: 1}},
+			window: 2,
+			want:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := hunksConflict(c.a, c.b, c.window); got != c.want {
+				t.Errorf("hunksConflict(%v, %v, %d) = %v, want %v", c.a, c.b, c.window, got, c.want)
+			}
+		})
+	}
+}
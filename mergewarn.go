@@ -1,166 +1,218 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"reflect"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"gopkg.in/libgit2/git2go.v22"
 	"gopkg.in/redis.v3"
+
+	"github.com/fundera/mergewarn/forge"
+	"github.com/fundera/mergewarn/gitbackend"
+	"github.com/fundera/mergewarn/watcher"
 )
 
 // FileEdit convert maps from above into structs for encoding
 type FileEdit struct {
-	Filename    string `json:"filename"`
-	LineNumbers []int  `json:"lineNumbers"`
-	User        string `json:"user"`
-	Branch      string `json:"branch"`
+	Filename   string            `json:"filename"`
+	Hunks      []gitbackend.Hunk `json:"hunks"`
+	BaseOID    string            `json:"baseOid,omitempty"`
+	User       string            `json:"user"`
+	Branch     string            `json:"branch"`
+	Repo       string            `json:"repo"`
+	BaseBranch string            `json:"baseBranch"`
+
+	// LineNumbers is a derived view over Hunks, kept for consumers that
+	// predate hunk-aware conflict detection.
+	LineNumbers []int `json:"lineNumbers"`
+}
+
+// lineNumbersFromHunks expands hunks into the flat, deduplicated,
+// sorted line list older consumers of FileEdit.LineNumbers expect.
+func lineNumbersFromHunks(hunks []gitbackend.Hunk) []int {
+	seen := make(map[int]bool)
+	for _, h := range hunks {
+		for l := h.StartLine; l < h.StartLine+h.LineCount; l++ {
+			seen[l] = true
+		}
+	}
+
+	lineNumbers := make([]int, 0, len(seen))
+	for l := range seen {
+		lineNumbers = append(lineNumbers, l)
+	}
+	sort.Ints(lineNumbers)
+	return lineNumbers
 }
 
 var redisURI = flag.String("uri", "localhost:6379", "Specify the Redis URI")
 var repoDirectory = flag.String("dir", ".", "Directory of the repository to track")
 var currentUser = flag.String("user", "", "Git user to trace back to")
 var redisPassword = flag.String("redispw", "", "Redis Password")
-
-func parseDiff(diff *git.Diff) map[string]map[int]bool {
-	fileEdits := make(map[string]map[int]bool)
-
-	_ = diff.ForEach(func(file git.DiffDelta, progress float64) (git.DiffForEachHunkCallback, error) {
-		return func(hunk git.DiffHunk) (git.DiffForEachLineCallback, error) {
-			return func(line git.DiffLine) error {
-				if line.Origin == git.DiffLineAddition || line.Origin == git.DiffLineDeletion {
-					var lineNumber int
-					if line.NewLineno > 0 {
-						lineNumber = line.NewLineno
-					} else {
-						lineNumber = line.OldLineno
-					}
-
-					path := file.OldFile.Path
-
-					if fileEdits[path] == nil {
-						fileEdits[path] = make(map[int]bool)
-					}
-
-					fileEdits[path][lineNumber] = true
-				}
-				return nil
-			}, nil
-
-		}, nil
-	}, git.DiffDetailLines)
-
-	return fileEdits
+var httpAddr = flag.String("http", "", "Address to serve the HTTP API on, e.g. :8080 (disabled if empty)")
+var baseBranch = flag.String("base", "master", "Base branch to diff against")
+var forgeKind = flag.String("forge", "", "Push conflict notifications to a forge: gitea or github (disabled if empty)")
+var forgeURL = flag.String("forge-url", "", "Base URL of the forge API (e.g. https://gitea.example.com, or blank for the public GitHub API)")
+var forgeToken = flag.String("forge-token", "", "API token for the forge")
+var repoSlug = flag.String("repo", "", "owner/name of the repository on the forge")
+var contextWindow = flag.Int("context", 3, "Line proximity within which two users' hunks are considered conflicting")
+var configFile = flag.String("config", "", "Path to a YAML/JSON config listing repos to track (overrides -dir/-base)")
+
+// defaultNamespace is the Redis namespace used when mergewarn is run
+// against a single repo via -dir/-base instead of -config.
+const defaultNamespace = "default"
+
+// diffParseNanos and redisReconnects back the /metrics endpoint. They're
+// touched from goroutines that don't otherwise share state, so they're
+// plain atomics rather than fields on some shared struct.
+var diffParseNanos int64
+var redisReconnects uint64
+
+// tracker holds everything needed to watch a single repository and report
+// its conflicts: the repo it's diffing, the shared Redis connection (keyed
+// under its own namespace), and the optional HTTP/forge integrations.
+type tracker struct {
+	redisClient *redis.Client
+	cfg         RepoConfig
+	api         *httpAPI
+	forgeClient forge.Forge
 }
 
-func getTreeRev(repo *git.Repository, branchName string) *git.Tree {
-	rev, err := repo.RevparseSingle(branchName + "^{tree}")
+func (t *tracker) diffsKey() string   { return fmt.Sprintf("mergewarn:%s:diffs", t.cfg.Namespace) }
+func (t *tracker) channelKey() string { return fmt.Sprintf("mergewarn:%s:newChange", t.cfg.Namespace) }
+
+// buildLocalFileEdits returns an error instead of calling log.Fatal on
+// failure: with chunk0-6's multi-repo daemon, a transient error in one
+// tracker (an index lock during a concurrent commit, a momentarily
+// detached HEAD, a flaky mount) must not take down every other tracked
+// repo's goroutine along with it.
+func (t *tracker) buildLocalFileEdits() ([]FileEdit, error) {
+	start := time.Now()
+	defer func() {
+		atomic.StoreInt64(&diffParseNanos, int64(time.Since(start)))
+	}()
+
+	backend, err := gitbackend.Open(t.cfg.Dir)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	tree, err := repo.LookupTree(rev.Id())
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return tree
-}
-
-func buildDiff(repo *git.Repository, branchName string) (*git.Diff, error) {
-	masterTree := getTreeRev(repo, "master")
-
-	if branchName == "master" {
-		// If we are working on master, then just diff against
-		// the current tree.
-		// TODO: is WithIndex the right thing here?
-		diff, err := repo.DiffTreeToWorkdirWithIndex(masterTree, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		return diff, err
+		return nil, err
 	}
 
-	otherTree := getTreeRev(repo, branchName)
-	opts, err := git.DefaultDiffOptions()
-	diff, err := repo.DiffTreeToTree(masterTree, otherTree, &opts)
+	branchName, err := backend.CurrentBranch()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return diff, err
-}
 
-func buildLocalFileEdits() []FileEdit {
-	repo, err := git.OpenRepository(*repoDirectory)
+	fileDiffs, err := backend.DiffAgainstBase(t.cfg.Base)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	head, _ := repo.Head()
-	branchName, _ := head.Branch().Name()
-
-	diff, _ := buildDiff(repo, branchName)
-	fileEdits := parseDiff(diff)
 
 	sanitizedFileEdits := []FileEdit{}
 
-	for tempFilename, lineNumberMap := range fileEdits {
+	for filename, fileDiff := range fileDiffs {
 		f := FileEdit{}
-		f.Filename = tempFilename
+		f.Filename = filename
 		f.User = *currentUser
 		f.Branch = branchName
+		f.Repo = t.cfg.Dir
+		f.BaseBranch = t.cfg.Base
+		f.Hunks = fileDiff.Hunks
+		f.BaseOID = fileDiff.BaseOID
+		f.LineNumbers = lineNumbersFromHunks(fileDiff.Hunks)
 
-		for l := range lineNumberMap {
-			f.LineNumbers = append(f.LineNumbers, l)
-		}
-
-		sort.Ints(f.LineNumbers)
 		sanitizedFileEdits = append(sanitizedFileEdits, f)
 	}
 
-	return sanitizedFileEdits
+	return sanitizedFileEdits, nil
 }
 
-func sendAndNotifyChange(redisClient *redis.Client, jsonBody []byte) {
-	redisClient.HSet("mergewarnDiffs", *currentUser, string(jsonBody))
-	redisClient.Publish("newChange", "1")
+func (t *tracker) sendAndNotifyChange(jsonBody []byte) {
+	t.redisClient.HSet(t.diffsKey(), *currentUser, string(jsonBody))
+	t.redisClient.Publish(t.channelKey(), "1")
 }
 
-func calculateConflicts(redisClient *redis.Client) (conflictFileEdits []FileEdit) {
-	allDiffs := redisClient.HGetAllMap("mergewarnDiffs")
+// fetchAllDiffs reads every user's last-known file edits back out of this
+// tracker's diffs hash, keyed by username.
+func (t *tracker) fetchAllDiffs() (map[string][]FileEdit, error) {
+	allDiffs := t.redisClient.HGetAllMap(t.diffsKey())
 	diffUserMap, err := allDiffs.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	userFileEdits := make(map[string][]FileEdit, len(diffUserMap))
+	for user, diffSet := range diffUserMap {
+		fileEdits := []FileEdit{}
+		json.Unmarshal([]byte(diffSet), &fileEdits)
+		userFileEdits[user] = fileEdits
+	}
 
-	localFileEdits := buildLocalFileEdits()
+	return userFileEdits, nil
+}
+
+// hunksConflict reports whether a and b overlap once each hunk in a is
+// padded by window lines on either side, i.e. they touch the same lines
+// or sit close enough together that one side's insertions are likely to
+// shift the other's line numbers.
+func hunksConflict(a, b []gitbackend.Hunk, window int) bool {
+	for _, ha := range a {
+		aStart := ha.StartLine - window
+		aEnd := ha.StartLine + ha.LineCount - 1 + window
+
+		for _, hb := range b {
+			bStart := hb.StartLine
+			bEnd := hb.StartLine + hb.LineCount - 1
+
+			if aStart <= bEnd && bStart <= aEnd {
+				return true
+			}
+		}
+	}
+	return false
+}
 
+func (t *tracker) calculateConflicts() (conflictFileEdits []FileEdit) {
+	diffUserMap, err := t.fetchAllDiffs()
 	if err != nil {
 		fmt.Println(err)
 	}
 
+	localFileEdits, err := t.buildLocalFileEdits()
+	if err != nil {
+		log.Println(t.cfg.Namespace, "- skipping conflict check:", err)
+		return nil
+	}
+
 	// {"filename":"frontend/stylesheets/bootstrap_application.css.sass","lineNumbers":[33]},{"filename":"package.json","lineNumbers":[1]}
-	for user, diffSet := range diffUserMap {
+	for user, incomingFileEdits := range diffUserMap {
 		if user != *currentUser {
-			incomingFileEdits := []FileEdit{}
-			json.Unmarshal([]byte(diffSet), &incomingFileEdits)
-
 			// iterate through each file diff and create a notice if that user is editing that line. Oh no!
 
 			for _, fileEdit := range incomingFileEdits {
 				for _, localFileEdit := range localFileEdits {
 					localFileEdit.User = user
 
-					// also check for line number collision here
+					// also check for hunk collision here
 					if localFileEdit.Filename == fileEdit.Filename {
 						shouldAdd := false
 
-						for _, localLineNumber := range localFileEdit.LineNumbers {
-							for _, remoteLineNumber := range fileEdit.LineNumbers {
-								if localLineNumber == remoteLineNumber {
-									shouldAdd = true
-								}
-							}
+						// Different base blobs mean we're comparing edits
+						// against different starting points for this file,
+						// so always warn rather than risk missing a
+						// conflict because line numbers happened to line up.
+						if localFileEdit.BaseOID != "" && fileEdit.BaseOID != "" && localFileEdit.BaseOID != fileEdit.BaseOID {
+							shouldAdd = true
+						} else if hunksConflict(localFileEdit.Hunks, fileEdit.Hunks, *contextWindow) {
+							shouldAdd = true
 						}
 
 						if shouldAdd {
@@ -175,7 +227,7 @@ func calculateConflicts(redisClient *redis.Client) (conflictFileEdits []FileEdit
 	return conflictFileEdits
 }
 
-func outputConflicts(conflicts []FileEdit) {
+func outputConflicts(namespace string, conflicts []FileEdit) {
 	jsonBody, err := json.Marshal(conflicts)
 
 	if err != nil {
@@ -185,13 +237,66 @@ func outputConflicts(conflicts []FileEdit) {
 	fmt.Print("INCOMING|")
 	fmt.Print(time.Now())
 	fmt.Print("|")
+	fmt.Print(namespace)
+	fmt.Print("|")
 	fmt.Print(string(jsonBody))
 	fmt.Print("\n")
 }
 
-func waitForServerChanges(redisClient *redis.Client) {
+// postForgeConflicts posts (or updates) a conflict notification on the
+// conflicting branch's open pull request, unless the conflict set is the
+// same one we posted last time. The last-posted hash lives in Redis, not
+// in memory, so this still dedupes correctly if the daemon restarts.
+//
+// previous is the conflict set from this tracker's previous check, used
+// only to recover the branch name when conflicts has just gone to zero
+// (FileEdit carries no branch of its own once there's nothing left to
+// report). When conflicts clears, this still posts an update rather than
+// skipping: leaving the old "potential conflicts" comment in place would
+// mislead reviewers, and storing the cleared set's hash (rather than just
+// returning) means a later recurrence of the exact same conflict is
+// posted again instead of being mistaken for a dupe of the stale comment.
+func (t *tracker) postForgeConflicts(conflicts, previous []FileEdit) {
+	branch := ""
+	switch {
+	case len(conflicts) > 0:
+		branch = conflicts[0].Branch
+	case len(previous) > 0:
+		branch = previous[0].Branch
+	default:
+		return
+	}
+
+	forgeConflicts := make([]forge.Conflict, len(conflicts))
+	for i, c := range conflicts {
+		forgeConflicts[i] = forge.Conflict{Filename: c.Filename, LineNumbers: c.LineNumbers, User: c.User, Branch: c.Branch}
+	}
+
+	jsonBody, err := json.Marshal(forgeConflicts)
+	if err != nil {
+		log.Println("forge:", err)
+		return
+	}
+	sum := sha1.Sum(jsonBody)
+	hash := hex.EncodeToString(sum[:])
+
+	hashField := t.cfg.Namespace + ":" + branch
+	lastHash, _ := t.redisClient.HGet("mergewarnForgePostedHash", hashField).Result()
+	if lastHash == hash {
+		return
+	}
+
+	if err := t.forgeClient.AnnotateConflict(branch, forgeConflicts); err != nil {
+		log.Println("forge:", err)
+		return
+	}
+
+	t.redisClient.HSet("mergewarnForgePostedHash", hashField, hash)
+}
+
+func (t *tracker) waitForServerChanges() {
 	var oldConflicts []FileEdit
-	pubsub, err := redisClient.Subscribe("newChange")
+	pubsub, err := t.redisClient.Subscribe(t.channelKey())
 	if err != nil {
 		panic("ERROR: Cannot connect to redis server. Make sure it is running at " + *redisURI)
 	}
@@ -201,6 +306,7 @@ func waitForServerChanges(redisClient *redis.Client) {
 		msgi, err := pubsub.Receive()
 
 		if err != nil {
+			atomic.AddUint64(&redisReconnects, 1)
 			err := pubsub.Ping("")
 			if err != nil {
 				panic(err)
@@ -210,10 +316,18 @@ func waitForServerChanges(redisClient *redis.Client) {
 		switch msg := msgi.(type) {
 		case *redis.Subscription:
 		case *redis.Message:
-			fetchedConflicts := calculateConflicts(redisClient)
+			fetchedConflicts := t.calculateConflicts()
 
 			if len(fetchedConflicts) > 0 || (len(fetchedConflicts) == 0 && len(oldConflicts) > 0) {
-				outputConflicts(fetchedConflicts)
+				outputConflicts(t.cfg.Namespace, fetchedConflicts)
+			}
+
+			if t.api != nil {
+				t.api.publishConflicts(t.cfg.Namespace, fetchedConflicts)
+			}
+
+			if t.forgeClient != nil {
+				t.postForgeConflicts(fetchedConflicts, oldConflicts)
 			}
 
 			oldConflicts = fetchedConflicts
@@ -225,38 +339,164 @@ func waitForServerChanges(redisClient *redis.Client) {
 	}
 }
 
-func waitForLocalChanges(redisClient *redis.Client) {
+func (t *tracker) checkAndSendFileEdits(lastFileEdits *[]FileEdit) {
+	fileEdits, err := t.buildLocalFileEdits()
+	if err != nil {
+		log.Println(t.cfg.Namespace, "- skipping this round:", err)
+		return
+	}
+
+	if !reflect.DeepEqual(*lastFileEdits, fileEdits) {
+		jsonBody, err := json.Marshal(fileEdits)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+		t.sendAndNotifyChange(jsonBody)
+		*lastFileEdits = fileEdits
+	}
+}
+
+// waitForLocalChangesPolling is the original 5-second poll-and-diff loop.
+// It's kept as a fallback for when the fsnotify watch on .git can't be
+// set up (e.g. the repo dir isn't a git repository, or inotify watches are
+// exhausted).
+func (t *tracker) waitForLocalChangesPolling() {
 	lastFileEdits := []FileEdit{}
 
 	for {
-		fileEdits := buildLocalFileEdits()
+		t.checkAndSendFileEdits(&lastFileEdits)
+		time.Sleep(5 * time.Second)
+	}
+}
 
-		if !reflect.DeepEqual(lastFileEdits, fileEdits) {
-			jsonBody, err := json.Marshal(fileEdits)
+func (t *tracker) waitForLocalChanges() {
+	w, err := watcher.New(t.cfg.Dir)
+	if err != nil {
+		log.Println("watcher: falling back to polling:", err)
+		t.waitForLocalChangesPolling()
+		return
+	}
+	defer w.Close()
 
-			if err != nil {
-				log.Fatal(err)
-			}
-			sendAndNotifyChange(redisClient, jsonBody)
-			lastFileEdits = fileEdits
-		}
-		time.Sleep(5 * time.Second)
+	lastFileEdits := []FileEdit{}
+
+	// Catch up once on startup, then react only to real changes.
+	t.checkAndSendFileEdits(&lastFileEdits)
+
+	for range w.Changes {
+		t.checkAndSendFileEdits(&lastFileEdits)
 	}
 }
 
+// run watches this tracker's repo for the life of the process.
+func (t *tracker) run() {
+	go t.waitForServerChanges()
+	t.waitForLocalChanges()
+}
+
+func runInstallHooks(args []string) {
+	fs := flag.NewFlagSet("install-hooks", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of the repository to install hooks into")
+	fs.Parse(args)
+
+	if err := watcher.InstallHooks(*dir); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Installed mergewarn git hooks in " + *dir)
+}
+
+// repoConfigs returns the repos mergewarn should track: the -config file's
+// list if one was given, otherwise a single entry built from -dir/-base.
+func repoConfigs() []RepoConfig {
+	if *configFile == "" {
+		return []RepoConfig{{Dir: *repoDirectory, Base: *baseBranch, Namespace: defaultNamespace}}
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Repos) == 0 {
+		log.Fatalf("config %s declares no repos", *configFile)
+	}
+	return cfg.Repos
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install-hooks" {
+		runInstallHooks(os.Args[2:])
+		return
+	}
+
 	fmt.Println("------------------------------")
 	fmt.Println("MergeWarn listener starting...")
 	fmt.Println("------------------------------")
 
+	flag.Parse()
+
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:     *redisURI,
 		Password: *redisPassword,
 		DB:       0, // use default DB
 	})
 
-	flag.Parse()
+	repos := repoConfigs()
 
-	go waitForServerChanges(redisClient)
-	waitForLocalChanges(redisClient)
+	var api *httpAPI
+	if *httpAddr != "" {
+		api = newHTTPAPI(redisClient, repos)
+		go api.Serve(*httpAddr)
+	}
+
+	// One forge client per repo slug, not one shared client: with more
+	// than one tracked repo, each needs its own conflict notifications
+	// posted against its own pull request rather than all of them landing
+	// on whichever slug -repo happened to name.
+	forgeClients := make(map[string]forge.Forge)
+	forgeClientFor := func(slug string) forge.Forge {
+		if slug == "" {
+			return nil
+		}
+		if f, ok := forgeClients[slug]; ok {
+			return f
+		}
+
+		var f forge.Forge
+		var err error
+		switch *forgeKind {
+		case "":
+			return nil
+		case "gitea":
+			f, err = forge.NewGitea(*forgeURL, *forgeToken, slug)
+		case "github":
+			f, err = forge.NewGitHub(*forgeURL, *forgeToken, slug)
+		default:
+			log.Fatalf("forge: unknown -forge %q (want gitea or github)", *forgeKind)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		forgeClients[slug] = f
+		return f
+	}
+
+	var wg sync.WaitGroup
+	for _, cfg := range repos {
+		slug := cfg.ForgeRepo
+		if slug == "" {
+			slug = *repoSlug
+		}
+		if *forgeKind != "" && slug == "" {
+			log.Println(cfg.Namespace, "- no forge_repo or -repo configured; conflict notifications disabled for this repo")
+		}
+
+		t := &tracker{redisClient: redisClient, cfg: cfg, api: api, forgeClient: forgeClientFor(slug)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.run()
+		}()
+	}
+	wg.Wait()
 }
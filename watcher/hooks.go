@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hookNames are the git hooks mergewarn installs. post-index-change isn't a
+// hook git itself invokes, but we still drop a script in place for tools
+// (or future git versions) that call it, same as the others.
+var hookNames = []string{"post-commit", "post-checkout", "post-merge", "post-index-change"}
+
+// hookMarker identifies a script InstallHooks itself wrote, so re-running
+// install-hooks can tell "already ours, just overwrite" apart from "some
+// other tool's hook, back it up first".
+const hookMarker = "# Installed by mergewarn install-hooks"
+
+// backupSuffix is appended to a pre-existing non-mergewarn hook's name when
+// it's moved aside to make room for ours.
+const backupSuffix = ".pre-mergewarn"
+
+const hookScriptTemplate = `#!/bin/sh
+%s. Pokes the running mergewarn
+# daemon so it recalculates local changes for this repo instead of waiting
+# on fsnotify or its polling fallback, then chains to any hook that was
+# here before. Writing to this repo's own .git dir (rather than signaling
+# the daemon process) keeps the wakeup scoped to this repo even when the
+# same daemon is tracking others.
+date +%%s%%N > "$(git rev-parse --git-dir)/%s" 2>/dev/null
+
+prev="$(dirname "$0")/%s"
+if [ -x "$prev" ]; then
+	exec "$prev" "$@"
+fi
+`
+
+func hookScript(name string) string {
+	return fmt.Sprintf(hookScriptTemplate, hookMarker, NotifyFile, name+backupSuffix)
+}
+
+// InstallHooks writes the mergewarn hook scripts into dir's .git/hooks,
+// mirroring the approach gitea's cmd/hook.go uses to drop its own hooks:
+// small POSIX shell scripts that shell out rather than re-exec the Go
+// binary. A hook of the same name we didn't install ourselves (husky,
+// pre-commit, lefthook, ...) is moved aside rather than clobbered, and our
+// script chains to it afterwards; re-running InstallHooks is a no-op on
+// top of an already-installed mergewarn hook. If that backup name is
+// already taken, InstallHooks returns an error instead of overwriting
+// either file.
+func InstallHooks(dir string) error {
+	hooksDir := filepath.Join(dir, ".git", "hooks")
+	if stat, err := os.Stat(hooksDir); err != nil || !stat.IsDir() {
+		return fmt.Errorf("watcher: %s is not a git repository (no %s)", dir, hooksDir)
+	}
+
+	for _, name := range hookNames {
+		path := filepath.Join(hooksDir, name)
+
+		existing, err := os.ReadFile(path)
+		if err == nil && !strings.Contains(string(existing), hookMarker) {
+			backupPath := path + backupSuffix
+			if _, err := os.Stat(backupPath); err == nil {
+				return fmt.Errorf("watcher: %s is not a mergewarn hook and %s already exists; not overwriting either", path, backupPath)
+			}
+			if err := os.Rename(path, backupPath); err != nil {
+				return fmt.Errorf("watcher: backing up existing %s: %v", path, err)
+			}
+		}
+
+		if err := os.WriteFile(path, []byte(hookScript(name)), 0755); err != nil {
+			return fmt.Errorf("watcher: writing %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Package watcher notifies the mergewarn daemon when the local working
+// tree has actually changed, instead of forcing it to poll and diff on a
+// timer. Changes are detected two ways, both watched through the same
+// fsnotify watch on .git, which is what keeps each tracked repo's
+// notifications scoped to that repo alone even though one daemon process
+// tracks many repos at once:
+//
+//   - fsnotify watches .git/index and .git/HEAD directly, which covers
+//     anything the git plumbing touches (checkouts, merges, commits, rebases).
+//   - installed git hooks (see InstallHooks) write to .git/mergewarn.notify,
+//     which covers operations that don't touch those files in a way
+//     fsnotify reliably picks up.
+//
+// Files in the working tree itself are not watched: an edit that leaves
+// .git untouched (an unstaged change to a tracked file) is not seen until
+// a later git operation or hook touches one of the watched files.
+//
+// An OS signal would also work to wake the daemon, but a signal is
+// delivered process-wide: with one daemon process watching N repos, a
+// hook firing in any one of them would wake every tracker, not just the
+// one that actually changed. Routing the wakeup through a file inside
+// that repo's own .git dir keeps it scoped to the fsnotify watch already
+// set up for that repo.
+//
+// If fsnotify can't be set up, New returns an error and the caller is
+// expected to fall back to polling.
+package watcher
+
+import (
+	"path/filepath"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// NotifyFile is the name of the file, inside .git, that installed hooks
+// write to in order to wake the watcher for that repo.
+const NotifyFile = "mergewarn.notify"
+
+// Watcher emits a value on Changes every time it believes the working tree
+// has changed.
+type Watcher struct {
+	fsWatcher *fsnotify.Watcher
+
+	Changes chan struct{}
+}
+
+// New starts watching dir for local changes. The caller must call Close
+// when done to release the fsnotify watcher.
+func New(dir string) (*Watcher, error) {
+	gitDir := filepath.Join(dir, ".git")
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fsWatcher.Add(gitDir); err != nil {
+		fsWatcher.Close()
+		return nil, err
+	}
+
+	w := &Watcher{
+		fsWatcher: fsWatcher,
+		Changes:   make(chan struct{}, 1),
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			switch filepath.Base(event.Name) {
+			case "index", "HEAD", NotifyFile:
+				w.notify()
+			}
+		case _, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// notify pushes a change event without blocking if one is already pending.
+func (w *Watcher) notify() {
+	select {
+	case w.Changes <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	return w.fsWatcher.Close()
+}